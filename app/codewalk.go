@@ -0,0 +1,141 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"bytes"
+	"doc"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Codewalk is a guided tour through a package: a sequence of annotated
+// steps, each pointing at a file and line range in the package's source
+// and a prose explanation of what's there. It's loaded from a ".codewalk"
+// file published in the package's repository alongside the Go sources.
+type Codewalk struct {
+	XMLName xml.Name       `xml:"codewalk"`
+	Title   string         `xml:"title,attr"`
+	Steps   []CodewalkStep `xml:"step"`
+}
+
+// CodewalkStep is one narrated stop in a Codewalk.
+type CodewalkStep struct {
+	File  string `xml:"file,attr"`
+	Line1 int    `xml:"line1,attr"`
+	Line2 int    `xml:"line2,attr"`
+	Prose string `xml:",chardata"`
+}
+
+// loadCodewalk parses a .codewalk file's contents, as fetched from pdoc's
+// repository by the doc crawler.
+func loadCodewalk(p []byte) (*Codewalk, error) {
+	var cw Codewalk
+	if err := xml.Unmarshal(p, &cw); err != nil {
+		return nil, err
+	}
+	return &cw, nil
+}
+
+// codewalkHandler serves /codewalk/<name>, rendering the split-pane
+// narrative/source view for the named codewalk within pdoc.
+//
+// pdoc.Codewalks and, in codewalkSourceFmt below, pdoc.Files are populated
+// by the doc crawler fetching the package's ".codewalk" files and source
+// alongside its regular crawl; that crawler doesn't exist in this tree, so
+// until it lands, codewalkHandler has no caller (see the package-page
+// router note on errorHandler in complete.go) and both maps always read
+// as empty.
+func codewalkHandler(w http.ResponseWriter, r *http.Request, pdoc *doc.Package, name string) error {
+	raw, ok := pdoc.Codewalks[name]
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+	cw, err := loadCodewalk(raw)
+	if err != nil {
+		return err
+	}
+	return executeTemplate(w, r, "codewalk.html", http.StatusOK, map[string]interface{}{
+		"pdoc":     pdoc,
+		"codewalk": cw,
+	})
+}
+
+// codewalkStepsFmt renders a Codewalk's steps as an ordered list of
+// prose/source pairs, each source excerpt rendered by codewalkSourceFmt
+// (identifiers in it are not linked to their doc pages). The returned
+// template.HTML is trusted: step.Prose is run through template.HTMLEscape
+// before being written. step.Prose is untrusted input -- it comes from a
+// ".codewalk" file in the package's own repository -- so that escape is
+// load-bearing, not defensive boilerplate.
+func codewalkStepsFmt(pdoc *doc.Package, cw *Codewalk) template.HTML {
+	var buf bytes.Buffer
+	buf.WriteString(`<ol class="codewalk-steps">`)
+	for i, step := range cw.Steps {
+		fmt.Fprintf(&buf, `<li id="step-%d"><p>`, i+1)
+		template.HTMLEscape(&buf, []byte(step.Prose))
+		buf.WriteString(`</p>`)
+		buf.WriteString(string(codewalkSourceFmt(pdoc, step)))
+		buf.WriteString(`</li>`)
+	}
+	buf.WriteString(`</ol>`)
+	return template.HTML(buf.String())
+}
+
+// codewalkSourceFmt renders the file/line-range a single CodewalkStep
+// points at, as a <pre> block of HTML-escaped source lines. Identifiers in
+// the excerpt are not linked to their doc pages. The returned template.HTML
+// is trusted: every source line is run through template.HTMLEscape before
+// being written.
+func codewalkSourceFmt(pdoc *doc.Package, step CodewalkStep) template.HTML {
+	src, ok := pdoc.Files[step.File]
+	if !ok {
+		return ""
+	}
+	lines := strings.Split(string(src), "\n")
+	lo := step.Line1 - 1
+	if lo < 0 {
+		lo = 0
+	}
+	if lo > len(lines) {
+		lo = len(lines)
+	}
+	// Line2 is optional: a step that only sets line1 is annotating that
+	// single line, not "the rest of the file".
+	hi := step.Line2
+	if hi == 0 {
+		hi = step.Line1
+	}
+	if hi > len(lines) {
+		hi = len(lines)
+	}
+	if hi < lo {
+		hi = lo
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<pre class="codewalk-source">`)
+	for _, line := range lines[lo:hi] {
+		template.HTMLEscape(&buf, []byte(line))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(`</pre>`)
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"doc"
+	"testing"
+)
+
+func TestCodewalkSourceFmt(t *testing.T) {
+	pdoc := &doc.Package{
+		Files: map[string][]byte{
+			"main.go": []byte("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"),
+		},
+	}
+	tests := []struct {
+		name string
+		step CodewalkStep
+		want string
+	}{
+		{
+			name: "line1 and line2 render that range",
+			step: CodewalkStep{File: "main.go", Line1: 3, Line2: 5},
+			want: "<pre class=\"codewalk-source\">func main() {\n\tprintln(&#34;hi&#34;)\n}\n</pre>",
+		},
+		{
+			name: "omitted line2 renders only line1, not the rest of the file",
+			step: CodewalkStep{File: "main.go", Line1: 3},
+			want: "<pre class=\"codewalk-source\">func main() {\n</pre>",
+		},
+		{
+			name: "line1 past the end of the file renders an empty block",
+			step: CodewalkStep{File: "main.go", Line1: 99},
+			want: `<pre class="codewalk-source"></pre>`,
+		},
+		{
+			name: "unknown file renders nothing",
+			step: CodewalkStep{File: "missing.go", Line1: 1, Line2: 2},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(codewalkSourceFmt(pdoc, tt.step))
+			if got != tt.want {
+				t.Errorf("codewalkSourceFmt(%+v) = %q, want %q", tt.step, got, tt.want)
+			}
+		})
+	}
+}
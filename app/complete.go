@@ -0,0 +1,177 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// completion is a single type-ahead suggestion: an import path or exported
+// identifier, ranked against the other entries sharing its prefix.
+type completion struct {
+	Text       string    `datastore:"text"`
+	ImportPath string    `datastore:"importPath"`
+	Score      int       `datastore:"score"`
+	Crawled    time.Time `datastore:"crawled"`
+}
+
+// completionKey returns the datastore key under which the completion for
+// text is stored. Entries
+// are keyed by their own text so re-indexing a package updates its entry
+// in place rather than appending duplicates.
+func completionKey(c appengine.Context, text string) *datastore.Key {
+	return datastore.NewKey(c, "Completion", text, 0, nil)
+}
+
+// indexCompletions records the given texts (an import path and, typically,
+// its exported identifiers) as completions for importPath, each with the
+// given score (such as the package's import count). Nothing in this tree
+// calls it: the doc crawler and its package-save path don't exist here (see
+// the note on readmeFmt in readme.go), so the index stays empty.
+func indexCompletions(c appengine.Context, importPath string, score int, texts []string) error {
+	now := time.Now()
+	keys := make([]*datastore.Key, 0, len(texts))
+	entries := make([]*completion, 0, len(texts))
+	for _, t := range texts {
+		t = strings.ToLower(t)
+		if t == "" {
+			continue
+		}
+		keys = append(keys, completionKey(c, t))
+		entries = append(entries, &completion{
+			Text:       t,
+			ImportPath: importPath,
+			Score:      score,
+			Crawled:    now,
+		})
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := datastore.PutMulti(c, keys, entries)
+	return err
+}
+
+// queryCompletions returns up to limit completions whose text has prefix,
+// ranked by popularity score, then by how closely the match's length
+// tracks the prefix, then by recency. Only the first 200 matches in text
+// order are fetched and ranked; a higher-ranked match past those is not
+// returned.
+func queryCompletions(c appengine.Context, prefix string, limit int) ([]*completion, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix == "" {
+		return nil, nil
+	}
+	q := datastore.NewQuery("Completion").
+		Filter("text >=", prefix).
+		Filter("text <", prefix+"\uffff").
+		Limit(200)
+	var matches []*completion
+	if _, err := q.GetAll(c, &matches); err != nil {
+		return nil, err
+	}
+	sort.Sort(byCompletionRank(matches))
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// byCompletionRank orders completions by popularity score, then by how
+// closely the match's length tracks the query prefix, then by recency.
+type byCompletionRank []*completion
+
+func (s byCompletionRank) Len() int      { return len(s) }
+func (s byCompletionRank) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCompletionRank) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score > s[j].Score
+	}
+	if len(s[i].Text) != len(s[j].Text) {
+		return len(s[i].Text) < len(s[j].Text)
+	}
+	return s[i].Crawled.After(s[j].Crawled)
+}
+
+// completeHandler serves /-/complete?q=..., returning JSON-encoded
+// suggestions for the search box's type-ahead.
+//
+// Nothing in this tree calls it yet: wiring it up means a small JS handler
+// on the search box, and this snapshot has no template/JS assets at all
+// (see the package-page router note on errorHandler below) for that JS to
+// live in. Until those land, /-/complete is reachable but has no caller.
+func completeHandler(w http.ResponseWriter, r *http.Request) error {
+	c := appengine.NewContext(r)
+	limit := 10
+	matches, err := queryCompletions(c, r.FormValue("q"), limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(matches)
+}
+
+// pruneCompletionsHandler serves /-/complete/prune, dropping completions
+// that have aged out of crawledBefore; this tree has no cron config that
+// requests it. It only removes entries; repopulating the index happens only
+// through indexCompletions, not from this handler, so it's safe to run
+// even if a rebuild would otherwise be infeasible (there's no corpus of
+// crawled packages to re-walk from here).
+func pruneCompletionsHandler(w http.ResponseWriter, r *http.Request) error {
+	c := appengine.NewContext(r)
+	crawledBefore := time.Now().Add(-completionTTL)
+	keys, err := datastore.NewQuery("Completion").
+		Filter("crawled <", crawledBefore).
+		KeysOnly().
+		GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(c, keys)
+}
+
+// completionTTL is how long a completion can go un-refreshed by the
+// crawler before pruneCompletionsHandler drops it.
+const completionTTL = 90 * 24 * time.Hour
+
+// errorHandler adapts a handler that can fail into an http.Handler,
+// logging the error and returning a 500 rather than requiring every
+// handler to do its own error plumbing. It's registered directly against
+// http.DefaultServeMux here because this tree has no central package-page
+// router to hang these routes off of; codewalkHandler, which additionally
+// needs a *doc.Package resolved from the request path, is wired into that
+// router instead once it exists.
+type errorHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (f errorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		log.Printf("app: %s: %v", r.URL.Path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	http.Handle("/-/complete", errorHandler(completeHandler))
+	http.Handle("/-/complete/prune", errorHandler(pruneCompletionsHandler))
+}
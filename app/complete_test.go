@@ -0,0 +1,74 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestByCompletionRank(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		in    []*completion
+		order []string
+	}{
+		{
+			name: "higher score wins",
+			in: []*completion{
+				{Text: "net/http", Score: 1, Crawled: now},
+				{Text: "net/httptest", Score: 10, Crawled: now},
+			},
+			order: []string{"net/httptest", "net/http"},
+		},
+		{
+			name: "tied score, shorter text wins",
+			in: []*completion{
+				{Text: "net/httptest", Score: 5, Crawled: now},
+				{Text: "net/http", Score: 5, Crawled: now},
+			},
+			order: []string{"net/http", "net/httptest"},
+		},
+		{
+			name: "tied score and length, more recent wins",
+			in: []*completion{
+				{Text: "net/http", Score: 5, Crawled: now.Add(-time.Hour)},
+				{Text: "net/smtp", Score: 5, Crawled: now},
+			},
+			order: []string{"net/smtp", "net/http"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort.Sort(byCompletionRank(tt.in))
+			got := make([]string, len(tt.in))
+			for i, c := range tt.in {
+				got[i] = c.Text
+			}
+			if len(got) != len(tt.order) {
+				t.Fatalf("got %v, want %v", got, tt.order)
+			}
+			for i := range got {
+				if got[i] != tt.order[i] {
+					t.Fatalf("got %v, want %v", got, tt.order)
+				}
+			}
+		})
+	}
+}
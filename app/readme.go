@@ -0,0 +1,144 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"bytes"
+	"doc"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+)
+
+// readmeSanitizer strips anything a README could use to break out of the
+// package page (script tags, inline event handlers, iframes, ...) while
+// still allowing the formatting markdown commonly produces.
+var readmeSanitizer = bluemonday.UGCPolicy()
+
+// readmeFmt renders pdoc's README as sanitized HTML, with relative links
+// and images rewritten to point at raw content hosted by the package's
+// upstream VCS, prefixed with a table of contents built from the rendered
+// h1/h2 headings that carry an id. blackfriday.MarkdownCommon gives a
+// heading an id only when the README sets one explicitly ("# Usage
+// {#usage}"), so headings without one are left out of the table of
+// contents. The returned template.HTML is trusted: it has already passed
+// through readmeSanitizer.
+//
+// pdoc.Readme is populated by the doc crawler fetching the package's
+// README alongside its regular crawl; that crawler doesn't exist in this
+// tree, so until it lands, pdoc.Readme always reads as empty and this
+// function is a no-op.
+func readmeFmt(pdoc *doc.Package) template.HTML {
+	if len(pdoc.Readme) == 0 {
+		return ""
+	}
+	html := blackfriday.MarkdownCommon(rewriteReadmeLinks(pdoc, pdoc.Readme))
+	html = readmeSanitizer.SanitizeBytes(html)
+	toc, body := extractReadmeTOC(html)
+	var buf bytes.Buffer
+	if len(toc) > 0 {
+		buf.WriteString(`<ul class="readme-toc">`)
+		buf.Write(toc)
+		buf.WriteString(`</ul>`)
+	}
+	buf.Write(body)
+	return template.HTML(buf.String())
+}
+
+// readmeLinkRE matches a link/image target already embedded as raw HTML
+// in the README source -- READMEs are free to mix inline HTML with
+// Markdown, and that inline HTML is untouched by readmeMarkdownLinkRE.
+var readmeLinkRE = regexp.MustCompile(`(?i)(<(?:a href|img src)=")([^"]+)(")`)
+
+// readmeMarkdownLinkRE matches a Markdown link or image's target --
+// "[text](target)" or "![alt](target)" -- which is how the overwhelming
+// majority of READMEs reference their own relative files, long before
+// blackfriday ever turns them into <a>/<img> tags.
+var readmeMarkdownLinkRE = regexp.MustCompile(`(!?\[[^\]]*\]\()([^)\s]+)(\))`)
+
+// rewriteReadmeLinks rewrites relative links and image sources in raw
+// (un-rendered) README markdown so they resolve against the raw content
+// host of the package's upstream VCS instead of 404ing on godoc.org.
+func rewriteReadmeLinks(pdoc *doc.Package, p []byte) []byte {
+	base := readmeRawBase(pdoc)
+	if base == "" {
+		return p
+	}
+	p = readmeMarkdownLinkRE.ReplaceAllFunc(p, rewriteReadmeLinkTarget(readmeMarkdownLinkRE, base))
+	p = readmeLinkRE.ReplaceAllFunc(p, rewriteReadmeLinkTarget(readmeLinkRE, base))
+	return p
+}
+
+// rewriteReadmeLinkTarget returns a ReplaceAllFunc callback that rewrites
+// the relative target re captured as its second group to be rooted at
+// base, leaving absolute URLs and in-page fragments alone.
+func rewriteReadmeLinkTarget(re *regexp.Regexp, base string) func([]byte) []byte {
+	return func(m []byte) []byte {
+		sub := re.FindSubmatch(m)
+		target := string(sub[2])
+		if strings.Contains(target, "://") || strings.HasPrefix(target, "#") {
+			return m
+		}
+		rewritten := append([]byte(nil), sub[1]...)
+		rewritten = append(rewritten, []byte(base+strings.TrimPrefix(target, "./"))...)
+		rewritten = append(rewritten, sub[3]...)
+		return rewritten
+	}
+}
+
+// readmeRawBase returns the URL prefix under which raw files from pdoc's
+// repository can be fetched, or "" if the host isn't recognized.
+func readmeRawBase(pdoc *doc.Package) string {
+	switch {
+	case strings.HasPrefix(pdoc.ProjectRoot, "github.com/"):
+		return fmt.Sprintf("https://raw.githubusercontent.com/%s/master/",
+			strings.TrimPrefix(pdoc.ProjectRoot, "github.com/"))
+	case strings.HasPrefix(pdoc.ProjectRoot, "bitbucket.org/"):
+		return fmt.Sprintf("https://bitbucket.org/%s/raw/default/",
+			strings.TrimPrefix(pdoc.ProjectRoot, "bitbucket.org/"))
+	case strings.HasPrefix(pdoc.ProjectRoot, "launchpad.net/"):
+		return fmt.Sprintf("https://bazaar.launchpad.net/+branch/%s/view/head:/",
+			strings.TrimPrefix(pdoc.ProjectRoot, "launchpad.net/"))
+	}
+	return ""
+}
+
+var readmeHeadingRE = regexp.MustCompile(`(?s)<h([12]) id="([^"]+)">(.*?)</h[12]>`)
+
+// extractReadmeTOC scans rendered README HTML for h1/h2 headings that
+// carry an id attribute and returns a list of <li> entries linking to
+// them; headings without an id are not listed. The HTML itself is
+// returned unchanged.
+func extractReadmeTOC(html []byte) (toc []byte, body []byte) {
+	matches := readmeHeadingRE.FindAllSubmatch(html, -1)
+	if len(matches) == 0 {
+		return nil, html
+	}
+	var buf bytes.Buffer
+	for _, m := range matches {
+		class := "readme-toc-item"
+		if string(m[1]) == "2" {
+			class += " readme-toc-sub"
+		}
+		fmt.Fprintf(&buf, `<li class="%s"><a href="#%s">%s</a></li>`, class, m[2], m[3])
+	}
+	return buf.Bytes(), html
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"doc"
+	"testing"
+)
+
+func TestExtractReadmeTOC(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		wantTOC  string
+		wantBody string
+	}{
+		{
+			name:     "no headings",
+			html:     `<p>just a paragraph</p>`,
+			wantTOC:  "",
+			wantBody: `<p>just a paragraph</p>`,
+		},
+		{
+			name: "h1 and h2 headings",
+			html: `<h1 id="intro">Intro</h1><p>text</p><h2 id="usage">Usage</h2>`,
+			wantTOC: `<li class="readme-toc-item"><a href="#intro">Intro</a></li>` +
+				`<li class="readme-toc-item readme-toc-sub"><a href="#usage">Usage</a></li>`,
+			wantBody: `<h1 id="intro">Intro</h1><p>text</p><h2 id="usage">Usage</h2>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toc, body := extractReadmeTOC([]byte(tt.html))
+			if string(toc) != tt.wantTOC {
+				t.Errorf("toc = %q, want %q", toc, tt.wantTOC)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRewriteReadmeLinks(t *testing.T) {
+	pdoc := &doc.Package{ProjectRoot: "github.com/golang/example"}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "relative image src is rewritten against raw host",
+			in:   `<img src="./doc/gopher.png">`,
+			want: `<img src="https://raw.githubusercontent.com/golang/example/master/doc/gopher.png">`,
+		},
+		{
+			name: "absolute url is left alone",
+			in:   `<a href="https://golang.org">golang.org</a>`,
+			want: `<a href="https://golang.org">golang.org</a>`,
+		},
+		{
+			name: "in-page fragment is left alone",
+			in:   `<a href="#usage">Usage</a>`,
+			want: `<a href="#usage">Usage</a>`,
+		},
+		{
+			name: "relative markdown image is rewritten against raw host",
+			in:   `![Gopher](./doc/gopher.png)`,
+			want: `![Gopher](https://raw.githubusercontent.com/golang/example/master/doc/gopher.png)`,
+		},
+		{
+			name: "relative markdown link is rewritten against raw host",
+			in:   `See [the docs](./doc/README.md) for more.`,
+			want: `See [the docs](https://raw.githubusercontent.com/golang/example/master/doc/README.md) for more.`,
+		},
+		{
+			name: "absolute markdown link is left alone",
+			in:   `[golang.org](https://golang.org)`,
+			want: `[golang.org](https://golang.org)`,
+		},
+		{
+			name: "in-page markdown fragment is left alone",
+			in:   `[Usage](#usage)`,
+			want: `[Usage](#usage)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(rewriteReadmeLinks(pdoc, []byte(tt.in)))
+			if got != tt.want {
+				t.Errorf("rewriteReadmeLinks(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
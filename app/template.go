@@ -23,12 +23,17 @@ import (
 	"errors"
 	"fmt"
 	godoc "go/doc"
+	"html"
+	"html/template"
+	"io"
+	"log"
 	"net/http"
 	"path"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
-	"text/template"
+	texttemplate "text/template"
 	"time"
 )
 
@@ -47,22 +52,24 @@ func mapFmt(kvs ...interface{}) (map[string]interface{}, error) {
 	return m, nil
 }
 
-// relativePathFmt formats an import path as HTML.
+// relativePathFmt formats an import path relative to parentPath.
 func relativePathFmt(importPath string, parentPath interface{}) string {
 	if p, ok := parentPath.(string); ok && p != "" && strings.HasPrefix(importPath, p) {
 		importPath = importPath[len(p)+1:]
 	}
-	return template.HTMLEscapeString(importPath)
+	return importPath
 }
 
-// importPathFmt formats an import with zero width space characters to allow for breeaks.
-func importPathFmt(importPath string) string {
+// importPathFmt formats an import with zero width space characters to
+// allow for breaks, as template.HTML so the inserted &#8203; entity isn't
+// re-escaped by the template engine.
+func importPathFmt(importPath string) template.HTML {
 	importPath = template.HTMLEscapeString(importPath)
 	if len(importPath) > 45 {
 		// Allow long import paths to break following "/"
 		importPath = strings.Replace(importPath, "/", "/&#8203;", -1)
 	}
-	return importPath
+	return template.HTML(importPath)
 }
 
 // relativeTime formats the time t in nanoseconds as a human readable relative
@@ -92,27 +99,160 @@ func relativeTime(t time.Time) string {
 }
 
 var (
-	h3Open     = []byte("<h3 ")
-	h4Open     = []byte("<h4 ")
-	h3Close    = []byte("</h3>")
-	h4Close    = []byte("</h4>")
-	rfcRE      = regexp.MustCompile(`RFC\s+(\d{3,4})`)
-	rfcReplace = []byte(`<a href="http://tools.ietf.org/html/rfc$1">$0</a>`)
+	h3Open  = []byte("<h3 ")
+	h4Open  = []byte("<h4 ")
+	h3Close = []byte("</h3>")
+	h4Close = []byte("</h4>")
 )
 
-// commentFmt formats a source code control comment as HTML.
-func commentFmt(v string) string {
+// commentFmt formats a source code control comment as HTML. The returned
+// template.HTML is trusted: it's built from godoc.ToHTML's escaped output
+// plus the handful of tags and links this func substitutes in afterward.
+func commentFmt(pdoc *doc.Package, v string) template.HTML {
 	var buf bytes.Buffer
 	godoc.ToHTML(&buf, v, nil)
 	p := buf.Bytes()
 	p = bytes.Replace(p, h3Open, h4Open, -1)
 	p = bytes.Replace(p, h3Close, h4Close, -1)
-	p = rfcRE.ReplaceAll(p, rfcReplace)
-	return string(p)
+	p = linkifyComment(p, pdoc)
+	return template.HTML(p)
+}
+
+// linkRecognizer finds one kind of reference in comment HTML (an RFC
+// number, a CVE id, an issue reference, ...) and resolves a match to the
+// href it should link to. Returning "" leaves the match unlinked, which
+// bareIssueHref does for packages not hosted on GitHub.
+type linkRecognizer struct {
+	name string
+	re   *regexp.Regexp
+	href func(match string, pdoc *doc.Package) string
+}
+
+// linkRecognizers is checked in order: entries must come before any other
+// entry whose pattern they're a more specific case of (golang/go#N before
+// the generic owner/repo#N, which in turn must come before the bare #N
+// fallback) so the combined regex built by commentLinkRE prefers them.
+var linkRecognizers = []linkRecognizer{
+	{"rfc", regexp.MustCompile(`RFC\s+\d{3,4}`), rfcHref},
+	{"cve", regexp.MustCompile(`CVE-\d{4}-\d+`), cveHref},
+	{"goissue", regexp.MustCompile(`golang/go#\d+`), goIssueHref},
+	{"issue", regexp.MustCompile(`[\w.-]+/[\w.-]+#\d+`), issueHref},
+	{"bareissue", regexp.MustCompile(`#\d+`), bareIssueHref},
+	{"url", regexp.MustCompile(`https?://[^\s<>"']+`), urlHref},
+}
+
+// commentLinkRE is linkRecognizers' patterns joined into a single
+// alternation, named so a match can be traced back to the recognizer that
+// produced it. This lets linkifyComment walk comment HTML once instead of
+// running every recognizer's pattern over it in turn.
+var commentLinkRE = func() *regexp.Regexp {
+	parts := make([]string, len(linkRecognizers))
+	for i, r := range linkRecognizers {
+		parts[i] = fmt.Sprintf("(?P<g%d>%s)", i, r.re.String())
+	}
+	return regexp.MustCompile(strings.Join(parts, "|"))
+}()
+
+// linkifyComment scans p for references recognized by linkRecognizers and
+// wraps each with an <a> pointing at the href its recognizer resolves,
+// using pdoc for recognizers (like bareIssueHref) that need per-package
+// context such as the upstream issue tracker.
+//
+// p is already HTML-escaped (it's godoc.ToHTML's output), so a match is
+// escaped text, not raw text -- e.g. a URL's "&" query separator reads as
+// "&amp;". The match is therefore written as the visible anchor text as
+// it is, for every recognizer. Every recognizer except urlHref builds its
+// href from regex-constrained substrings (digits, owner/repo path
+// segments) that can't contain escaped entities, so escaping that href is
+// safe for them. urlHref's href *is* the match, so it must be written raw
+// instead of escaped a second time.
+//
+// godoc.ToHTML links URLs itself and escapes quotes as "&#34;" and "&#39;",
+// so a match inside a tag, inside one of its anchors, or right after an
+// entity's "&" is written back unlinked.
+func linkifyComment(p []byte, pdoc *doc.Package) []byte {
+	names := commentLinkRE.SubexpNames()
+	var out bytes.Buffer
+	last := 0
+	for _, loc := range commentLinkRE.FindAllSubmatchIndex(p, -1) {
+		i := -1
+		for g := 2; g < len(loc); g += 2 {
+			if loc[g] >= 0 {
+				i, _ = strconv.Atoi(strings.TrimPrefix(names[g/2], "g"))
+				break
+			}
+		}
+		match := string(p[loc[0]:loc[1]])
+		href := linkRecognizers[i].href(match, pdoc)
+		before := p[:loc[0]]
+		if bytes.LastIndexByte(before, '<') > bytes.LastIndexByte(before, '>') ||
+			bytes.LastIndex(before, []byte("<a ")) > bytes.LastIndex(before, []byte("</a>")) ||
+			bytes.HasSuffix(before, []byte("&")) {
+			href = ""
+		}
+		out.Write(p[last:loc[0]])
+		switch {
+		case href == "":
+			out.WriteString(match)
+		case linkRecognizers[i].name == "url":
+			// match is already-escaped HTML equal to href; neither needs
+			// (or should get) another pass through HTMLEscape.
+			out.WriteString(`<a href="`)
+			out.WriteString(href)
+			out.WriteString(`">`)
+			out.WriteString(match)
+			out.WriteString(`</a>`)
+		default:
+			out.WriteString(`<a href="`)
+			template.HTMLEscape(&out, []byte(href))
+			out.WriteString(`">`)
+			out.WriteString(match)
+			out.WriteString(`</a>`)
+		}
+		last = loc[1]
+	}
+	out.Write(p[last:])
+	return out.Bytes()
+}
+
+var rfcDigitsRE = regexp.MustCompile(`\d+`)
+
+func rfcHref(match string, pdoc *doc.Package) string {
+	return "http://tools.ietf.org/html/rfc" + rfcDigitsRE.FindString(match)
+}
+
+func cveHref(match string, pdoc *doc.Package) string {
+	return "http://cve.mitre.org/cgi-bin/cvename.cgi?name=" + match
+}
+
+func goIssueHref(match string, pdoc *doc.Package) string {
+	return "https://github.com/golang/go/issues/" + match[strings.IndexByte(match, '#')+1:]
 }
 
-// declFmt formats a Decl as HTML.
-func declFmt(decl doc.Decl) string {
+func issueHref(match string, pdoc *doc.Package) string {
+	i := strings.IndexByte(match, '#')
+	return "https://github.com/" + match[:i] + "/issues/" + match[i+1:]
+}
+
+// bareIssueHref resolves a bare "#123" reference against pdoc's project
+// URL; it only produces a link when pdoc is hosted on GitHub, since a bare
+// issue number is meaningless without knowing which tracker it belongs to.
+func bareIssueHref(match string, pdoc *doc.Package) string {
+	if pdoc == nil || !strings.HasPrefix(pdoc.ProjectRoot, "github.com/") {
+		return ""
+	}
+	return "https://github.com/" + strings.TrimPrefix(pdoc.ProjectRoot, "github.com/") + "/issues/" + match[1:]
+}
+
+func urlHref(match string, pdoc *doc.Package) string {
+	return match
+}
+
+// declFmt formats a Decl as HTML, linking identifiers it annotates to
+// their doc pages. The returned template.HTML is trusted: every byte of
+// decl.Text not covered by an annotation link is run through
+// template.HTMLEscape before being written.
+func declFmt(decl doc.Decl) template.HTML {
 	var buf bytes.Buffer
 	last := 0
 	t := []byte(decl.Text)
@@ -139,15 +279,18 @@ func declFmt(decl doc.Decl) string {
 		}
 	}
 	template.HTMLEscape(&buf, t[last:])
-	return buf.String()
+	return template.HTML(buf.String())
 }
 
 func commandNameFmt(pdoc *doc.Package) string {
 	_, name := path.Split(pdoc.ImportPath)
-	return template.HTMLEscapeString(name)
+	return name
 }
 
-func breadcrumbsFmt(pdoc *doc.Package) string {
+// breadcrumbsFmt renders the import-path breadcrumb trail above a package
+// page as HTML. The returned template.HTML is trusted: every path segment
+// is run through template.HTMLEscape before being written.
+func breadcrumbsFmt(pdoc *doc.Package) template.HTML {
 	importPath := []byte(pdoc.ImportPath)
 	var buf bytes.Buffer
 	i := 0
@@ -169,46 +312,272 @@ func breadcrumbsFmt(pdoc *doc.Package) string {
 		j = bytes.IndexByte(importPath[i:], '/')
 	}
 	template.HTMLEscape(&buf, importPath[i:])
-	return buf.String()
+	return template.HTML(buf.String())
+}
+
+// baseFuncs are the template funcs common to every output format. It's an
+// unnamed map type (rather than template.FuncMap) so it's assignable to
+// both html/template's and text/template's FuncMap without a conversion.
+var baseFuncs = map[string]interface{}{
+	"comment":        commentFmt,
+	"decl":           declFmt,
+	"equal":          reflect.DeepEqual,
+	"map":            mapFmt,
+	"breadcrumbs":    breadcrumbsFmt,
+	"commandName":    commandNameFmt,
+	"relativePath":   relativePathFmt,
+	"relativeTime":   relativeTime,
+	"importPath":     importPathFmt,
+	"readme":         readmeFmt,
+	"codewalkSteps":  codewalkStepsFmt,
+	"codewalkSource": codewalkSourceFmt,
 }
 
-func executeTemplate(w http.ResponseWriter, name string, status int, data interface{}) error {
-	s := templateSet
-	if appengine.IsDevAppServer() {
-		var err error
-		s, err = parseTemplates()
-		if err != nil {
-			return err
+// outputFormat describes one of the ways a page can be rendered: its own
+// template set (parsed from glob with baseFuncs plus any format-specific
+// funcs), MIME type, and the URL suffix that selects it (e.g.
+// "/net/http.json" selects jsonFormat). html controls which template
+// package renders it: true selects html/template's context-aware
+// auto-escaping (appropriate for a browser-facing format), false selects
+// text/template, which writes func results verbatim. JSON, plain-text and
+// Markdown output must use the latter -- a doc comment's "<" or "&"
+// getting HTML-entity-escaped would corrupt it as an API response.
+type outputFormat struct {
+	name     string
+	mimeType string
+	suffix   string
+	glob     string
+	html     bool
+	funcs    map[string]interface{}
+}
+
+var (
+	// htmlFormat keeps the original, un-prefixed template/*.html glob: its
+	// templates predate the other formats and were never moved into a
+	// template/html subdirectory.
+	htmlFormat = &outputFormat{
+		name:     "html",
+		mimeType: "text/html; charset=utf-8",
+		glob:     "template/*.html",
+		html:     true,
+	}
+	jsonFormat = &outputFormat{
+		name:     "json",
+		mimeType: "application/json; charset=utf-8",
+		suffix:   ".json",
+		glob:     "template/json/*.html",
+		funcs:    textFuncs,
+	}
+	textFormat = &outputFormat{
+		name:     "text",
+		mimeType: "text/plain; charset=utf-8",
+		suffix:   ".txt",
+		glob:     "template/text/*.html",
+		funcs:    textFuncs,
+	}
+	markdownFormat = &outputFormat{
+		name:     "markdown",
+		mimeType: "text/markdown; charset=utf-8",
+		suffix:   ".md",
+		glob:     "template/markdown/*.html",
+		funcs:    textFuncs,
+	}
+)
+
+// outputFormats lists the formats in the order their suffixes are matched
+// against the request path; htmlFormat has no suffix and is always last.
+var outputFormats = []*outputFormat{jsonFormat, textFormat, markdownFormat, htmlFormat}
+
+// negotiateFormat chooses an outputFormat for r, preferring a URL suffix
+// match (e.g. "/fmt.Errorf.json") and falling back to the Accept header,
+// defaulting to HTML when neither names a known format.
+func negotiateFormat(r *http.Request) (*outputFormat, string) {
+	p := r.URL.Path
+	for _, f := range outputFormats {
+		if f.suffix != "" && strings.HasSuffix(p, f.suffix) {
+			return f, strings.TrimSuffix(p, f.suffix)
 		}
 	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(status)
-	return s.ExecuteTemplate(w, name, data)
+	accept := r.Header.Get("Accept")
+	for _, f := range outputFormats {
+		if f.mimeType != "" && strings.Contains(accept, strings.SplitN(f.mimeType, ";", 2)[0]) {
+			return f, p
+		}
+	}
+	return htmlFormat, p
 }
 
-var templateSet *template.Template
+// commentTextFmt formats a source code control comment as plain text,
+// stripping the HTML that commentFmt would otherwise add and the blank
+// lines around it.
+func commentTextFmt(pdoc *doc.Package, v string) string {
+	return strings.TrimSpace(stripHTML(string(commentFmt(pdoc, v))))
+}
 
-func parseTemplates() (*template.Template, error) {
+// declTextFmt formats a Decl as plain text, stripping the cross-reference
+// links that declFmt embeds as HTML anchors.
+func declTextFmt(decl doc.Decl) string {
+	return stripHTML(string(declFmt(decl)))
+}
+
+// readmeTextFmt formats a README as plain text, stripping the HTML
+// (headings, table of contents links) that readmeFmt would otherwise add.
+func readmeTextFmt(pdoc *doc.Package) string {
+	return stripHTML(string(readmeFmt(pdoc)))
+}
+
+// breadcrumbsTextFmt formats the import-path breadcrumb trail as plain
+// text, stripping the anchors breadcrumbsFmt embeds as HTML.
+func breadcrumbsTextFmt(pdoc *doc.Package) string {
+	return stripHTML(string(breadcrumbsFmt(pdoc)))
+}
+
+// importPathTextFmt formats an import path as plain text, without the
+// zero-width-space break hints importPathFmt inserts for a browser.
+func importPathTextFmt(importPath string) string {
+	return importPath
+}
+
+// codewalkStepsTextFmt formats a Codewalk's steps as plain text, stripping
+// the HTML codewalkStepsFmt would otherwise add.
+func codewalkStepsTextFmt(pdoc *doc.Package, cw *Codewalk) string {
+	return stripHTML(string(codewalkStepsFmt(pdoc, cw)))
+}
+
+// codewalkSourceTextFmt formats a CodewalkStep's source excerpt as plain
+// text, stripping the HTML codewalkSourceFmt would otherwise add.
+func codewalkSourceTextFmt(pdoc *doc.Package, step CodewalkStep) string {
+	return stripHTML(string(codewalkSourceFmt(pdoc, step)))
+}
+
+// textFuncs override every baseFuncs entry that renders HTML (anchors,
+// headings, the &#8203; break hints importPathFmt inserts) with plain-text
+// equivalents. Every non-HTML output format needs these: JSON and
+// Markdown are "machine-consumable" formats that must not come back full
+// of embedded <a> tags, and textFormat is meant to read like `go doc`
+// output.
+var textFuncs = map[string]interface{}{
+	"comment":        commentTextFmt,
+	"decl":           declTextFmt,
+	"readme":         readmeTextFmt,
+	"breadcrumbs":    breadcrumbsTextFmt,
+	"importPath":     importPathTextFmt,
+	"codewalkSteps":  codewalkStepsTextFmt,
+	"codewalkSource": codewalkSourceTextFmt,
+}
+
+var htmlTagRE = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML removes tags from s and unescapes the entities godoc.ToHTML
+// and declFmt introduce (including importPathFmt's literal "&#8203;"),
+// leaving the plain text those functions formatted.
+func stripHTML(s string) string {
+	return html.UnescapeString(htmlTagRE.ReplaceAllString(s, ""))
+}
+
+// templateSet is the subset of *html/template.Template and
+// *text/template.Template that templateLoader needs; it lets templateLoader
+// hold either depending on the output format's html flag.
+type templateSet interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// templateLoader owns one output format's FuncMap and ParseGlob call. It
+// caches the parsed set once loaded; in dev_appserver it re-parses on
+// every Execute so template edits show up without a server restart.
+type templateLoader struct {
+	format *outputFormat
+	cached templateSet
+}
+
+func (l *templateLoader) funcs() map[string]interface{} {
+	funcs := make(map[string]interface{}, len(baseFuncs)+len(l.format.funcs))
+	for k, v := range baseFuncs {
+		funcs[k] = v
+	}
+	for k, v := range l.format.funcs {
+		funcs[k] = v
+	}
+	return funcs
+}
+
+func (l *templateLoader) set() (templateSet, error) {
+	if l.cached != nil && !appengine.IsDevAppServer() {
+		return l.cached, nil
+	}
+	var set templateSet
+	var err error
+	if l.format.html {
+		set, err = l.parseHTML()
+	} else {
+		set, err = l.parseText()
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.cached = set
+	return set, nil
+}
+
+// parseHTML builds l's template set with html/template, whose contextual
+// auto-escaping is what the browser-facing HTML format needs.
+func (l *templateLoader) parseHTML() (*template.Template, error) {
 	// Is there a better way to call ParseGlob with application specified
 	// funcs? The dummy template thing is gross.
-	set, err := template.New("__dummy__").Parse(`{{define "__dummy__"}}{{end}}`)
+	t, err := template.New("__dummy__").Parse(`{{define "__dummy__"}}{{end}}`)
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs(l.funcs())
+	return t.ParseGlob(l.format.glob)
+}
+
+// parseText builds l's template set with text/template, which writes func
+// results verbatim. JSON, plain-text and Markdown must use this: running
+// them through html/template's auto-escaping would HTML-entity-escape
+// "<"/"&" in doc comments and corrupt the output.
+func (l *templateLoader) parseText() (*texttemplate.Template, error) {
+	t, err := texttemplate.New("__dummy__").Parse(`{{define "__dummy__"}}{{end}}`)
 	if err != nil {
 		return nil, err
 	}
-	set.Funcs(template.FuncMap{
-		"comment":      commentFmt,
-		"decl":         declFmt,
-		"equal":        reflect.DeepEqual,
-		"map":          mapFmt,
-		"breadcrumbs":  breadcrumbsFmt,
-		"commandName":  commandNameFmt,
-		"relativePath": relativePathFmt,
-		"relativeTime": relativeTime,
-		"importPath":   importPathFmt,
-	})
-	return set.ParseGlob("template/*.html")
+	t.Funcs(l.funcs())
+	return t.ParseGlob(l.format.glob)
+}
+
+// Execute renders the named template from l's format against data.
+func (l *templateLoader) Execute(name string, w io.Writer, data interface{}) error {
+	set, err := l.set()
+	if err != nil {
+		return err
+	}
+	return set.ExecuteTemplate(w, name, data)
+}
+
+var templateLoaders = map[string]*templateLoader{}
+
+func executeTemplate(w http.ResponseWriter, r *http.Request, name string, status int, data interface{}) error {
+	format, _ := negotiateFormat(r)
+	loader, ok := templateLoaders[format.name]
+	if !ok {
+		return fmt.Errorf("app: no templates registered for output format %q", format.name)
+	}
+	w.Header().Set("Content-Type", format.mimeType)
+	w.WriteHeader(status)
+	return loader.Execute(name, w, data)
 }
 
 func init() {
-	templateSet = template.Must(parseTemplates())
+	for _, format := range outputFormats {
+		loader := &templateLoader{format: format}
+		if _, err := loader.set(); err != nil {
+			// A format whose templates haven't been added yet (e.g. a new
+			// non-HTML format landing ahead of its template/ directory)
+			// shouldn't take the whole app down; it's simply unavailable
+			// until those templates show up.
+			log.Printf("app: not registering output format %q: %v", format.name, err)
+			continue
+		}
+		templateLoaders[format.name] = loader
+	}
 }
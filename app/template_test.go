@@ -0,0 +1,118 @@
+// Copyright 2013 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"doc"
+	"testing"
+)
+
+func TestLinkifyComment(t *testing.T) {
+	pdoc := &doc.Package{ProjectRoot: "github.com/golang/example"}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "rfc",
+			in:   "see RFC 2616 for details",
+			want: `see <a href="http://tools.ietf.org/html/rfc2616">RFC 2616</a> for details`,
+		},
+		{
+			name: "cve",
+			in:   "fixed in CVE-2014-0160",
+			want: `fixed in <a href="http://cve.mitre.org/cgi-bin/cvename.cgi?name=CVE-2014-0160">CVE-2014-0160</a>`,
+		},
+		{
+			name: "owner/repo issue reference",
+			in:   "fixes golang/example#42",
+			want: `fixes <a href="https://github.com/golang/example/issues/42">golang/example#42</a>`,
+		},
+		{
+			name: "golang/go issue reference takes the more specific recognizer",
+			in:   "fixes golang/go#42",
+			want: `fixes <a href="https://github.com/golang/go/issues/42">golang/go#42</a>`,
+		},
+		{
+			name: "bare issue reference resolves against pdoc.ProjectRoot",
+			in:   "fixes #42",
+			want: `fixes <a href="https://github.com/golang/example/issues/42">#42</a>`,
+		},
+		{
+			name: "bare url with query string is not double-escaped",
+			in:   "see http://example.com/x?a=1&amp;b=2 for details",
+			want: `see <a href="http://example.com/x?a=1&amp;b=2">http://example.com/x?a=1&amp;b=2</a> for details`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(linkifyComment([]byte(tt.in), pdoc))
+			if got != tt.want {
+				t.Errorf("linkifyComment(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "strips tags",
+			in:   `<a href="/net/http">http</a> package`,
+			want: "http package",
+		},
+		{
+			name: "unescapes entities introduced by HTMLEscape",
+			in:   "a &lt;-chan int &amp; error",
+			want: "a <-chan int & error",
+		},
+		{
+			name: "unescapes importPathFmt's zero-width-space break hints",
+			in:   "net/&#8203;http",
+			want: "net/​http",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripHTML(tt.in); got != tt.want {
+				t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclTextFmt(t *testing.T) {
+	decl := doc.Decl{Text: "func Foo(c <-chan int) error"}
+	want := "func Foo(c <-chan int) error"
+	if got := declTextFmt(decl); got != want {
+		t.Errorf("declTextFmt(%+v) = %q, want %q", decl, got, want)
+	}
+}
+
+func TestCommentTextFmt(t *testing.T) {
+	pdoc := &doc.Package{}
+	in := "Foo returns an error if a & b overflow."
+	want := "Foo returns an error if a & b overflow."
+	if got := commentTextFmt(pdoc, in); got != want {
+		t.Errorf("commentTextFmt(%q) = %q, want %q", in, got, want)
+	}
+}